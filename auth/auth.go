@@ -0,0 +1,95 @@
+// Package auth implements the shared-token challenge-response scheme used
+// to authenticate clients: the server hands a connecting client a random
+// nonce, and the client must prove it holds one of the server's tokens by
+// returning HMAC-SHA256(token, nonce||timestamp) — the token itself never
+// goes on the wire.
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// NonceSize is the size, in bytes, of a login challenge's nonce.
+const NonceSize = 32
+
+// MaxClockSkew bounds how far a client's timestamp may drift from the
+// server's before its signature is rejected, so a captured challenge
+// response can't be replayed long after the fact.
+const MaxClockSkew = 30 * time.Second
+
+// NewNonce returns a fresh random nonce for a login challenge.
+func NewNonce() ([]byte, error) {
+	nonce := make([]byte, NonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("generate nonce: %v", err)
+	}
+	return nonce, nil
+}
+
+// Sign computes the response a holder of token must give to a challenge.
+func Sign(token string, nonce []byte, timestamp int64) string {
+	mac := hmac.New(sha256.New, []byte(token))
+	mac.Write(nonce)
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// TokenACL is what a named token authorizes its holder to do. An empty
+// AllowedPorts or AllowedSubdomains list allows any port/domain, and a
+// MaxTunnels of 0 means unlimited, matching the zero-value-means-"no
+// restriction" convention the rest of the server config already uses.
+type TokenACL struct {
+	Name              string   `yaml:"name"`
+	Token             string   `yaml:"token"`
+	AllowedPorts      []int    `yaml:"allowed_ports"`
+	AllowedSubdomains []string `yaml:"allowed_subdomains"`
+	MaxTunnels        int      `yaml:"max_tunnels"`
+}
+
+// Registry looks up which token, if any, produced a given challenge
+// signature.
+type Registry struct {
+	tokens map[string]*TokenACL
+}
+
+// NewRegistry builds a Registry from a list of named tokens. Entries with
+// a duplicate token are the last one wins.
+func NewRegistry(tokens []TokenACL) *Registry {
+	r := &Registry{tokens: make(map[string]*TokenACL, len(tokens))}
+	for i := range tokens {
+		acl := tokens[i]
+		r.tokens[acl.Token] = &acl
+	}
+	return r
+}
+
+// Len returns the number of tokens registered.
+func (r *Registry) Len() int {
+	return len(r.tokens)
+}
+
+// Authenticate finds the token whose signature matches, returning its
+// ACL. It rejects a stale timestamp before comparing against any token so
+// a replayed response can't be brute-forced against the whole registry.
+func (r *Registry) Authenticate(nonce []byte, timestamp int64, signature string, now time.Time) (*TokenACL, error) {
+	skew := now.Sub(time.Unix(timestamp, 0))
+	if skew < 0 {
+		skew = -skew
+	}
+	if skew > MaxClockSkew {
+		return nil, fmt.Errorf("timestamp skew %s exceeds allowed %s", skew, MaxClockSkew)
+	}
+
+	for _, acl := range r.tokens {
+		if hmac.Equal([]byte(Sign(acl.Token, nonce, timestamp)), []byte(signature)) {
+			return acl, nil
+		}
+	}
+	return nil, fmt.Errorf("no token matches the provided signature")
+}
@@ -0,0 +1,85 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAuthenticateSuccess(t *testing.T) {
+	r := NewRegistry([]TokenACL{{Name: "a", Token: "secret-a"}, {Name: "b", Token: "secret-b"}})
+
+	nonce, err := NewNonce()
+	if err != nil {
+		t.Fatalf("NewNonce: %v", err)
+	}
+	now := time.Unix(1_700_000_000, 0)
+	sig := Sign("secret-b", nonce, now.Unix())
+
+	acl, err := r.Authenticate(nonce, now.Unix(), sig, now)
+	if err != nil {
+		t.Fatalf("Authenticate returned error: %v", err)
+	}
+	if acl.Name != "b" {
+		t.Errorf("got token %q, want %q", acl.Name, "b")
+	}
+}
+
+func TestAuthenticateWrongToken(t *testing.T) {
+	r := NewRegistry([]TokenACL{{Name: "a", Token: "secret-a"}})
+
+	nonce, _ := NewNonce()
+	now := time.Unix(1_700_000_000, 0)
+	sig := Sign("not-the-token", nonce, now.Unix())
+
+	if _, err := r.Authenticate(nonce, now.Unix(), sig, now); err == nil {
+		t.Error("expected an error for a signature that matches no token")
+	}
+}
+
+func TestAuthenticateStaleTimestamp(t *testing.T) {
+	r := NewRegistry([]TokenACL{{Name: "a", Token: "secret-a"}})
+
+	nonce, _ := NewNonce()
+	ts := time.Unix(1_700_000_000, 0)
+	sig := Sign("secret-a", nonce, ts.Unix())
+
+	now := ts.Add(MaxClockSkew + time.Second)
+	if _, err := r.Authenticate(nonce, ts.Unix(), sig, now); err == nil {
+		t.Error("expected an error for a timestamp outside MaxClockSkew")
+	}
+}
+
+func TestAuthenticateFutureTimestampWithinSkew(t *testing.T) {
+	r := NewRegistry([]TokenACL{{Name: "a", Token: "secret-a"}})
+
+	nonce, _ := NewNonce()
+	ts := time.Unix(1_700_000_000, 0)
+	sig := Sign("secret-a", nonce, ts.Unix())
+
+	now := ts.Add(-MaxClockSkew / 2)
+	if _, err := r.Authenticate(nonce, ts.Unix(), sig, now); err != nil {
+		t.Errorf("expected timestamp within MaxClockSkew to be accepted, got: %v", err)
+	}
+}
+
+func TestNewRegistryDuplicateTokenLastWins(t *testing.T) {
+	r := NewRegistry([]TokenACL{
+		{Name: "first", Token: "shared"},
+		{Name: "second", Token: "shared"},
+	})
+	if r.Len() != 1 {
+		t.Fatalf("got %d tokens, want 1", r.Len())
+	}
+
+	nonce, _ := NewNonce()
+	now := time.Unix(1_700_000_000, 0)
+	sig := Sign("shared", nonce, now.Unix())
+
+	acl, err := r.Authenticate(nonce, now.Unix(), sig, now)
+	if err != nil {
+		t.Fatalf("Authenticate returned error: %v", err)
+	}
+	if acl.Name != "second" {
+		t.Errorf("got token %q, want %q", acl.Name, "second")
+	}
+}
@@ -6,30 +6,45 @@ import (
 	"log"
 	"net"
 	"os"
-	"strconv"
 	"strings"
 	"time"
 
 	"gopkg.in/yaml.v2"
+
+	"github.com/Ambitiousnoob/noobtunnel/auth"
+	"github.com/Ambitiousnoob/noobtunnel/msg"
+	"github.com/Ambitiousnoob/noobtunnel/session"
 )
 
+// heartbeatInterval is how often the client pings the server on the
+// control stream; heartbeatTimeout is how long the server will wait
+// before deciding the client is gone (see Server.handleTunnel).
+const heartbeatInterval = 30 * time.Second
+
 type ClientConfig struct {
-	Server      string            `yaml:"server"`
-	Tunnels     map[string]Tunnel `yaml:"tunnels"`
-	Reconnect   bool              `yaml:"reconnect"`
-	ReconnectDelay int            `yaml:"reconnect_delay"`
-	LogLevel    string            `yaml:"log_level"`
+	Server         string                  `yaml:"server"`
+	ClientID       string                  `yaml:"client_id"`
+	Token          string                  `yaml:"token"`
+	Tunnels        map[string]TunnelConfig `yaml:"tunnels"`
+	SubdomainHost  string                  `yaml:"subdomain_host"`
+	Reconnect      bool                    `yaml:"reconnect"`
+	ReconnectDelay int                     `yaml:"reconnect_delay"`
+	LogLevel       string                  `yaml:"log_level"`
 }
 
 type TunnelConfig struct {
-	LocalPort  int    `yaml:"local_port"`
-	RemotePort int    `yaml:"remote_port"`
-	LocalHost  string `yaml:"local_host"`
+	Type          string   `yaml:"type"`
+	LocalPort     int      `yaml:"local_port"`
+	RemotePort    int      `yaml:"remote_port"`
+	LocalHost     string   `yaml:"local_host"`
+	CustomDomains []string `yaml:"custom_domains"`
+	Subdomain     string   `yaml:"subdomain"`
 }
 
 type Client struct {
-	config *ClientConfig
-	conn   net.Conn
+	config  *ClientConfig
+	conn    net.Conn
+	session *session.Session
 }
 
 func startClient(server string, localPort, remotePort int) {
@@ -68,16 +83,72 @@ func (c *Client) startWithConfig() {
 	// In a production version, you'd handle multiple tunnels concurrently
 	for name, tunnel := range c.config.Tunnels {
 		fmt.Printf("\n🚀 Starting tunnel: %s\n", name)
-		c.connectAndTunnel(c.config.Server, tunnel.LocalPort, tunnel.RemotePort, tunnel.LocalHost)
+		if strings.EqualFold(tunnel.Type, "http") || strings.EqualFold(tunnel.Type, "https") {
+			domains := c.resolveDomains(tunnel)
+			if len(domains) == 0 {
+				log.Fatalf("❌ Tunnel %q is type http but has no custom_domains or subdomain configured", name)
+			}
+			c.connectAndTunnelHTTP(c.config.Server, tunnel.LocalPort, tunnel.LocalHost, domains)
+		} else {
+			c.connectAndTunnel(c.config.Server, tunnel.LocalPort, tunnel.RemotePort, tunnel.LocalHost)
+		}
 		break
 	}
 }
 
+// resolveDomains turns a tunnel's custom_domains and subdomain settings
+// into the fully-qualified hostnames the server should route to it. The
+// subdomain is joined with the client's configured subdomain_host, which
+// must match the base domain the server's vhost listeners actually serve.
+func (c *Client) resolveDomains(tunnel TunnelConfig) []string {
+	domains := append([]string{}, tunnel.CustomDomains...)
+	if tunnel.Subdomain != "" && c.config.SubdomainHost != "" {
+		domains = append(domains, fmt.Sprintf("%s.%s", tunnel.Subdomain, c.config.SubdomainHost))
+	}
+	return domains
+}
+
 func (c *Client) connectAndTunnel(server string, localPort, remotePort int, localHost string) {
 	if localHost == "" {
 		localHost = "127.0.0.1"
 	}
 
+	proxy := msg.NewProxy{
+		ProxyName:  fmt.Sprintf("tcp-%d", remotePort),
+		ProxyType:  "tcp",
+		RemotePort: remotePort,
+	}
+	onReady := func(resp *msg.NewProxyResp) {
+		fmt.Printf("🌐 Your local service %s:%d is now accessible via the server on %s\n", localHost, localPort, resp.RemoteAddr)
+	}
+
+	c.serve(server, proxy, localHost, localPort, onReady)
+}
+
+// connectAndTunnelHTTP registers localHost:localPort under one or more
+// vhost domains instead of a dedicated remote port, so it can share the
+// server's HTTP/HTTPS vhost listeners with other clients' tunnels.
+func (c *Client) connectAndTunnelHTTP(server string, localPort int, localHost string, domains []string) {
+	if localHost == "" {
+		localHost = "127.0.0.1"
+	}
+
+	proxy := msg.NewProxy{
+		ProxyName:     fmt.Sprintf("http-%s", strings.Join(domains, "-")),
+		ProxyType:     "http",
+		CustomDomains: domains,
+	}
+	onReady := func(resp *msg.NewProxyResp) {
+		fmt.Printf("🌐 Your local service %s:%d is now accessible via %s\n", localHost, localPort, resp.RemoteAddr)
+	}
+
+	c.serve(server, proxy, localHost, localPort, onReady)
+}
+
+// serve owns the connect/login/register/relay/reconnect loop shared by
+// every tunnel type; proxy describes the handshake sent on the control
+// stream and onReady is called once the server confirms it's live.
+func (c *Client) serve(server string, proxy msg.NewProxy, localHost string, localPort int, onReady func(*msg.NewProxyResp)) {
 	for {
 		fmt.Printf("🔌 Connecting to %s...\n", server)
 		conn, err := net.DialTimeout("tcp", server, 10*time.Second)
@@ -91,46 +162,54 @@ func (c *Client) connectAndTunnel(server string, localPort, remotePort int, loca
 		c.conn = conn
 		fmt.Printf("✅ Connected to server %s\n", server)
 
-		// Send tunnel request
-		request := fmt.Sprintf("TUNNEL %d", remotePort)
-		if _, err := conn.Write([]byte(request)); err != nil {
-			log.Printf("❌ Failed to send tunnel request: %v", err)
+		sess, err := session.Client(conn)
+		if err != nil {
+			log.Printf("❌ Failed to establish multiplexed session: %v", err)
 			conn.Close()
 			time.Sleep(5 * time.Second)
 			continue
 		}
+		c.session = sess
 
-		// Read response
-		buffer := make([]byte, 1024)
-		conn.SetReadDeadline(time.Now().Add(10 * time.Second))
-		n, err := conn.Read(buffer)
+		// The control stream is always the first stream opened, so the
+		// server's first accepted stream lines up with it.
+		control, err := sess.OpenStream()
 		if err != nil {
-			log.Printf("❌ Failed to read server response: %v", err)
+			log.Printf("❌ Failed to open control stream: %v", err)
+			sess.Close()
 			conn.Close()
 			time.Sleep(5 * time.Second)
 			continue
 		}
 
-		response := string(buffer[:n])
-		if strings.HasPrefix(response, "ERROR") {
-			log.Printf("❌ Server error: %s", response[6:])
+		if err := c.login(control); err != nil {
+			log.Printf("❌ Login failed: %v", err)
+			sess.Close()
 			conn.Close()
-			return
+			if strings.Contains(err.Error(), "server rejected login") {
+				return
+			}
+			time.Sleep(5 * time.Second)
+			continue
 		}
 
-		if strings.HasPrefix(response, "OK") {
-			fmt.Printf("🎯 %s\n", response[3:])
-			fmt.Printf("🌐 Your local service %s:%d is now accessible via the server on port %d\n", localHost, localPort, remotePort)
-			fmt.Println("📡 Tunnel is active, waiting for connections...")
-		} else {
-			log.Printf("⚠️ Unexpected response: %s", response)
+		resp, err := c.registerProxy(control, proxy)
+		if err != nil {
+			log.Printf("❌ Failed to register tunnel: %v", err)
+			sess.Close()
+			conn.Close()
+			return
 		}
 
-		// Handle tunnel connections
-		if err := c.handleTunnel(localHost, localPort); err != nil {
+		fmt.Printf("🎯 Tunnel established: %s\n", resp.RemoteAddr)
+		onReady(resp)
+		fmt.Println("📡 Tunnel is active, waiting for connections...")
+
+		if err := c.handleTunnel(control, localHost, localPort); err != nil {
 			log.Printf("❌ Tunnel error: %v", err)
 		}
 
+		sess.Close()
 		conn.Close()
 		fmt.Println("🔌 Disconnected from server")
 
@@ -149,53 +228,160 @@ func (c *Client) connectAndTunnel(server string, localPort, remotePort int, loca
 	}
 }
 
-func (c *Client) handleTunnel(localHost string, localPort int) error {
-	buffer := make([]byte, 1024)
+// login answers the server's auth challenge and waits for it to confirm
+// or reject the connection. The client never sends its token over the
+// wire: it proves possession by signing the nonce the server just handed
+// it, the same scheme the server's auth package verifies with.
+func (c *Client) login(control net.Conn) error {
+	control.SetReadDeadline(time.Now().Add(10 * time.Second))
+	challengeMsg, err := msg.ReadMsg(control)
+	control.SetReadDeadline(time.Time{})
+	if err != nil {
+		return fmt.Errorf("read auth challenge: %v", err)
+	}
+	challenge, ok := challengeMsg.(*msg.AuthChallenge)
+	if !ok {
+		return fmt.Errorf("unexpected message %T waiting for auth challenge", challengeMsg)
+	}
 
-	for {
-		// Wait for connection signal from server
-		n, err := c.conn.Read(buffer)
-		if err != nil {
-			return fmt.Errorf("connection lost: %v", err)
-		}
+	timestamp := time.Now().Unix()
+	login := &msg.Login{
+		ProtoVersion: msg.ProtoVersion,
+		ClientID:     c.clientID(),
+		Timestamp:    timestamp,
+		Signature:    auth.Sign(c.token(), challenge.Nonce, timestamp),
+	}
+	if err := msg.WriteMsg(control, login); err != nil {
+		return fmt.Errorf("send login: %v", err)
+	}
 
-		signal := string(buffer[:n])
-		if !strings.HasPrefix(signal, "CONN") {
-			continue
-		}
+	control.SetReadDeadline(time.Now().Add(10 * time.Second))
+	defer control.SetReadDeadline(time.Time{})
 
-		// Extract port from signal
-		parts := strings.Split(signal, " ")
-		if len(parts) != 2 {
-			continue
-		}
+	respMsg, err := msg.ReadMsg(control)
+	if err != nil {
+		return fmt.Errorf("read login response: %v", err)
+	}
+	resp, ok := respMsg.(*msg.LoginResp)
+	if !ok {
+		return fmt.Errorf("unexpected message %T during login", respMsg)
+	}
+	if resp.Error != "" {
+		return fmt.Errorf("server rejected login: %s", resp.Error)
+	}
+	return nil
+}
 
-		port, err := strconv.Atoi(parts[1])
-		if err != nil {
-			continue
-		}
+func (c *Client) clientID() string {
+	if c.config != nil {
+		return c.config.ClientID
+	}
+	return ""
+}
+
+func (c *Client) token() string {
+	if c.config != nil {
+		return c.config.Token
+	}
+	return ""
+}
+
+func (c *Client) registerProxy(control net.Conn, proxy msg.NewProxy) (*msg.NewProxyResp, error) {
+	if err := msg.WriteMsg(control, &proxy); err != nil {
+		return nil, fmt.Errorf("send new proxy request: %v", err)
+	}
 
-		log.Printf("📞 Incoming connection on port %d", port)
+	control.SetReadDeadline(time.Now().Add(10 * time.Second))
+	defer control.SetReadDeadline(time.Time{})
 
-		// Connect to local service
-		localAddr := fmt.Sprintf("%s:%d", localHost, localPort)
-		localConn, err := net.DialTimeout("tcp", localAddr, 5*time.Second)
+	respMsg, err := msg.ReadMsg(control)
+	if err != nil {
+		return nil, fmt.Errorf("read new proxy response: %v", err)
+	}
+	resp, ok := respMsg.(*msg.NewProxyResp)
+	if !ok {
+		return nil, fmt.Errorf("unexpected message %T registering proxy", respMsg)
+	}
+	if resp.Error != "" {
+		return nil, fmt.Errorf("server error: %s", resp.Error)
+	}
+	return resp, nil
+}
+
+// handleTunnel keeps the control stream's heartbeat alive, accepts one
+// multiplexed stream per public connection the server relays to us (tcp
+// and http tunnels are indistinguishable from this point on), and pipes
+// each to the local service.
+func (c *Client) handleTunnel(control net.Conn, localHost string, localPort int) error {
+	controlErr := make(chan error, 1)
+
+	go func() {
+		ticker := time.NewTicker(heartbeatInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := msg.WriteMsg(control, &msg.Ping{}); err != nil {
+				return
+			}
+		}
+	}()
+
+	go func() {
+		for {
+			control.SetReadDeadline(time.Now().Add(heartbeatTimeout))
+			m, err := msg.ReadMsg(control)
+			control.SetReadDeadline(time.Time{})
+			if err != nil {
+				controlErr <- fmt.Errorf("control stream closed: %v", err)
+				c.session.Close()
+				return
+			}
+			if _, ok := m.(*msg.CloseProxy); ok {
+				controlErr <- fmt.Errorf("server closed the tunnel")
+				c.session.Close()
+				return
+			}
+			// Pong just confirms liveness; nothing else to do with it.
+		}
+	}()
+
+	for {
+		stream, err := c.session.AcceptStream()
 		if err != nil {
-			log.Printf("❌ Failed to connect to local service %s: %v", localAddr, err)
-			continue
+			select {
+			case cErr := <-controlErr:
+				return cErr
+			default:
+				return fmt.Errorf("connection lost: %v", err)
+			}
 		}
 
-		log.Printf("🔗 Connected to local service %s", localAddr)
+		go c.handleStream(stream, localHost, localPort)
+	}
+}
 
-		// Relay data between local service and tunnel
-		go func() {
-			io.Copy(c.conn, localConn)
-			localConn.Close()
-		}()
+func (c *Client) handleStream(stream net.Conn, localHost string, localPort int) {
+	defer stream.Close()
+
+	if _, err := msg.ReadMsg(stream); err != nil {
+		log.Printf("⚠️ Failed to read work connection header: %v", err)
+		return
+	}
 
-		go func() {
-			io.Copy(localConn, c.conn)
-			c.conn.Close()
-		}()
+	localAddr := fmt.Sprintf("%s:%d", localHost, localPort)
+	localConn, err := net.DialTimeout("tcp", localAddr, 5*time.Second)
+	if err != nil {
+		log.Printf("❌ Failed to connect to local service %s: %v", localAddr, err)
+		return
 	}
-}
\ No newline at end of file
+	defer localConn.Close()
+
+	log.Printf("🔗 Connected to local service %s", localAddr)
+
+	// Relay data between local service and tunnel stream
+	go func() {
+		io.Copy(stream, localConn)
+		localConn.Close()
+	}()
+
+	io.Copy(localConn, stream)
+}
@@ -0,0 +1,169 @@
+// Package dashboard exposes a server's live tunnel state over HTTP: a
+// small JSON API for operators plus a Prometheus-compatible /metrics
+// endpoint. It has no knowledge of tunnels, sessions, or yamux directly —
+// it's driven entirely through the Source interface, the same way the
+// vhost package is driven through Registration without knowing how a
+// client session was established.
+package dashboard
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TunnelInfo is the subset of a tunnel's state the dashboard exposes.
+type TunnelInfo struct {
+	Port       int       `json:"port"`
+	ProxyName  string    `json:"proxy_name"`
+	ClientAddr string    `json:"client_addr"`
+	CreatedAt  time.Time `json:"created_at"`
+	BytesIn    int64     `json:"bytes_in"`
+	BytesOut   int64     `json:"bytes_out"`
+	Streams    int       `json:"streams"`
+}
+
+// Source is whatever backs the dashboard's live state; Server implements it.
+type Source interface {
+	Tunnels() []TunnelInfo
+	CloseTunnel(port int) bool
+	ConnectionsRejected() map[string]int64
+	RateLimited() int64
+}
+
+// Dashboard serves the operator-facing HTTP API and /metrics endpoint.
+type Dashboard struct {
+	source   Source
+	user     string
+	password string
+}
+
+// New returns a Dashboard backed by source. If user is empty, the API is
+// served without basic auth.
+func New(source Source, user, password string) *Dashboard {
+	return &Dashboard{source: source, user: user, password: password}
+}
+
+// Start runs the dashboard's HTTP server on addr. Like
+// http.ListenAndServe, it blocks until the server stops, so callers run
+// it in its own goroutine.
+func (d *Dashboard) Start(addr string) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/tunnels", d.handleTunnels)
+	mux.HandleFunc("/api/clients", d.handleClients)
+	mux.HandleFunc("/api/tunnels/", d.handleTunnelByPort)
+	mux.HandleFunc("/metrics", d.handleMetrics)
+	return http.ListenAndServe(addr, d.withAuth(mux))
+}
+
+func (d *Dashboard) withAuth(next http.Handler) http.Handler {
+	if d.user == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		validUser := subtle.ConstantTimeCompare([]byte(user), []byte(d.user)) == 1
+		validPass := subtle.ConstantTimeCompare([]byte(pass), []byte(d.password)) == 1
+		if !ok || !validUser || !validPass {
+			w.Header().Set("WWW-Authenticate", `Basic realm="noobtunnel dashboard"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (d *Dashboard) handleTunnels(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, d.source.Tunnels())
+}
+
+func (d *Dashboard) handleClients(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	byClient := make(map[string][]TunnelInfo)
+	for _, t := range d.source.Tunnels() {
+		host := t.ClientAddr
+		if h, _, err := net.SplitHostPort(t.ClientAddr); err == nil {
+			host = h
+		}
+		byClient[host] = append(byClient[host], t)
+	}
+	writeJSON(w, byClient)
+}
+
+// handleTunnelByPort implements DELETE /api/tunnels/{port}.
+func (d *Dashboard) handleTunnelByPort(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	portStr := strings.TrimPrefix(r.URL.Path, "/api/tunnels/")
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		http.Error(w, "invalid port", http.StatusBadRequest)
+		return
+	}
+
+	if !d.source.CloseTunnel(port) {
+		http.Error(w, "tunnel not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (d *Dashboard) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	tunnels := d.source.Tunnels()
+	sort.Slice(tunnels, func(i, j int) bool { return tunnels[i].Port < tunnels[j].Port })
+
+	var buf strings.Builder
+
+	fmt.Fprint(&buf, "# HELP noobtunnel_active_tunnels Number of tunnels currently registered.\n")
+	fmt.Fprint(&buf, "# TYPE noobtunnel_active_tunnels gauge\n")
+	fmt.Fprintf(&buf, "noobtunnel_active_tunnels %d\n", len(tunnels))
+
+	fmt.Fprint(&buf, "# HELP noobtunnel_bytes_transferred_total Bytes relayed through tunnels.\n")
+	fmt.Fprint(&buf, "# TYPE noobtunnel_bytes_transferred_total counter\n")
+	for _, t := range tunnels {
+		port := strconv.Itoa(t.Port)
+		fmt.Fprintf(&buf, "noobtunnel_bytes_transferred_total{direction=\"in\",port=%q} %d\n", port, t.BytesIn)
+		fmt.Fprintf(&buf, "noobtunnel_bytes_transferred_total{direction=\"out\",port=%q} %d\n", port, t.BytesOut)
+	}
+
+	fmt.Fprint(&buf, "# HELP noobtunnel_connections_rejected_total Connections rejected before a tunnel was created.\n")
+	fmt.Fprint(&buf, "# TYPE noobtunnel_connections_rejected_total counter\n")
+	rejected := d.source.ConnectionsRejected()
+	reasons := make([]string, 0, len(rejected))
+	for reason := range rejected {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	for _, reason := range reasons {
+		fmt.Fprintf(&buf, "noobtunnel_connections_rejected_total{reason=%q} %d\n", reason, rejected[reason])
+	}
+
+	fmt.Fprint(&buf, "# HELP noobtunnel_rate_limited_total Connections rejected by the per-IP rate limiter.\n")
+	fmt.Fprint(&buf, "# TYPE noobtunnel_rate_limited_total counter\n")
+	fmt.Fprintf(&buf, "noobtunnel_rate_limited_total %d\n", d.source.RateLimited())
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write([]byte(buf.String()))
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,29 @@
+// Package metrics provides the small pieces needed to track bytes
+// relayed through a tunnel as they flow, rather than only once a copy
+// loop exits, so the dashboard's /metrics endpoint reflects long-lived
+// connections in real time.
+package metrics
+
+import (
+	"io"
+	"sync/atomic"
+)
+
+// CountingWriter wraps w, atomically adding the length of every
+// successful write to *counter. The caller owns counter and reads it
+// with atomic.LoadInt64.
+type CountingWriter struct {
+	w       io.Writer
+	counter *int64
+}
+
+// NewCountingWriter returns a CountingWriter that accumulates into counter.
+func NewCountingWriter(w io.Writer, counter *int64) *CountingWriter {
+	return &CountingWriter{w: w, counter: counter}
+}
+
+func (c *CountingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	atomic.AddInt64(c.counter, int64(n))
+	return n, err
+}
@@ -0,0 +1,193 @@
+// Package msg defines the versioned control protocol exchanged between
+// client and server, replacing the old ad-hoc ASCII commands ("TUNNEL %d",
+// "OK ...", "ERROR ...", "CONN %d"). Every message is framed on the wire
+// as:
+//
+//	[1-byte type][8-byte big-endian length][JSON payload]
+//
+// ReadMsg/WriteMsg handle that framing; callers work with the typed
+// structs below.
+package msg
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+type msgType byte
+
+const (
+	TypeAuthChallenge msgType = 'a'
+	TypeLogin         msgType = 'l'
+	TypeLoginResp     msgType = 'L'
+	TypeNewProxy      msgType = 'p'
+	TypeNewProxyResp  msgType = 'P'
+	TypeNewWorkConn   msgType = 'w'
+	TypeCloseProxy    msgType = 'c'
+	TypePing          msgType = 'h'
+	TypePong          msgType = 'H'
+)
+
+// ProtoVersion is bumped whenever the wire format changes incompatibly, so
+// a future client/server pair can reject or adapt to a mismatch instead of
+// failing on a garbled read.
+const ProtoVersion = 1
+
+// maxPayloadSize bounds how large a single message's JSON payload may be,
+// so a misbehaving peer can't make ReadMsg allocate without limit.
+const maxPayloadSize = 1 << 20 // 1 MiB
+
+// Message is implemented by every control message type.
+type Message interface {
+	typeID() msgType
+}
+
+// AuthChallenge is the first message the server sends on the control
+// stream: a random nonce the client must fold into its login signature so
+// the signature can't be replayed against a future connection.
+type AuthChallenge struct {
+	Nonce []byte `json:"nonce"`
+}
+
+func (*AuthChallenge) typeID() msgType { return TypeAuthChallenge }
+
+// Login answers an AuthChallenge. Signature is
+// HMAC-SHA256(token, nonce||timestamp) computed by the client over the
+// nonce it was just sent; the token itself never goes on the wire.
+type Login struct {
+	ProtoVersion int    `json:"proto_version"`
+	ClientID     string `json:"client_id,omitempty"`
+	Timestamp    int64  `json:"timestamp"`
+	Signature    string `json:"signature,omitempty"`
+}
+
+func (*Login) typeID() msgType { return TypeLogin }
+
+// LoginResp answers a Login. Error is empty on success.
+type LoginResp struct {
+	Version string `json:"version"`
+	Error   string `json:"error,omitempty"`
+}
+
+func (*LoginResp) typeID() msgType { return TypeLoginResp }
+
+// NewProxy asks the server to expose a local service, either on a fixed
+// remote TCP port or under one or more HTTP/HTTPS vhost domains.
+type NewProxy struct {
+	ProxyName     string   `json:"proxy_name"`
+	ProxyType     string   `json:"proxy_type"` // "tcp" or "http"
+	RemotePort    int      `json:"remote_port,omitempty"`
+	CustomDomains []string `json:"custom_domains,omitempty"`
+}
+
+func (*NewProxy) typeID() msgType { return TypeNewProxy }
+
+// NewProxyResp answers a NewProxy. Error is empty on success.
+type NewProxyResp struct {
+	ProxyName  string `json:"proxy_name"`
+	RemoteAddr string `json:"remote_addr,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+func (*NewProxyResp) typeID() msgType { return TypeNewProxyResp }
+
+// NewWorkConn is written as the first message on every stream the server
+// opens to carry one public connection's traffic, so the client knows
+// which proxy the stream belongs to before it starts relaying raw bytes.
+type NewWorkConn struct {
+	ProxyName string `json:"proxy_name"`
+}
+
+func (*NewWorkConn) typeID() msgType { return TypeNewWorkConn }
+
+// CloseProxy tells the peer a proxy is going away so it can clean up
+// without waiting for the heartbeat to time out.
+type CloseProxy struct {
+	ProxyName string `json:"proxy_name"`
+}
+
+func (*CloseProxy) typeID() msgType { return TypeCloseProxy }
+
+// Ping and Pong are exchanged on the control stream every 30s to detect a
+// half-open socket promptly, the same way SSH's keepalive@openssh.com does.
+type Ping struct{}
+
+func (*Ping) typeID() msgType { return TypePing }
+
+type Pong struct{}
+
+func (*Pong) typeID() msgType { return TypePong }
+
+// WriteMsg frames and writes m to w.
+func WriteMsg(w io.Writer, m Message) error {
+	payload, err := json.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("marshal %T: %v", m, err)
+	}
+
+	header := make([]byte, 9)
+	header[0] = byte(m.typeID())
+	binary.BigEndian.PutUint64(header[1:], uint64(len(payload)))
+
+	if _, err := w.Write(header); err != nil {
+		return fmt.Errorf("write header: %v", err)
+	}
+	if _, err := w.Write(payload); err != nil {
+		return fmt.Errorf("write payload: %v", err)
+	}
+	return nil
+}
+
+// ReadMsg reads and decodes one framed message from r.
+func ReadMsg(r io.Reader) (Message, error) {
+	header := make([]byte, 9)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+
+	length := binary.BigEndian.Uint64(header[1:])
+	if length > maxPayloadSize {
+		return nil, fmt.Errorf("message payload too large: %d bytes", length)
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, fmt.Errorf("read payload: %v", err)
+	}
+
+	m, err := newMessage(msgType(header[0]))
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(payload, m); err != nil {
+		return nil, fmt.Errorf("unmarshal %T: %v", m, err)
+	}
+	return m, nil
+}
+
+func newMessage(t msgType) (Message, error) {
+	switch t {
+	case TypeAuthChallenge:
+		return &AuthChallenge{}, nil
+	case TypeLogin:
+		return &Login{}, nil
+	case TypeLoginResp:
+		return &LoginResp{}, nil
+	case TypeNewProxy:
+		return &NewProxy{}, nil
+	case TypeNewProxyResp:
+		return &NewProxyResp{}, nil
+	case TypeNewWorkConn:
+		return &NewWorkConn{}, nil
+	case TypeCloseProxy:
+		return &CloseProxy{}, nil
+	case TypePing:
+		return &Ping{}, nil
+	case TypePong:
+		return &Pong{}, nil
+	default:
+		return nil, fmt.Errorf("unknown message type: %q", t)
+	}
+}
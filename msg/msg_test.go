@@ -0,0 +1,73 @@
+package msg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestWriteReadMsgRoundTrip(t *testing.T) {
+	cases := []Message{
+		&AuthChallenge{Nonce: []byte("nonce-bytes")},
+		&Login{ProtoVersion: ProtoVersion, ClientID: "c1", Timestamp: 1700000000, Signature: "sig"},
+		&LoginResp{Version: "1.0.0"},
+		&LoginResp{Error: "bad signature"},
+		&NewProxy{ProxyName: "web", ProxyType: "http", CustomDomains: []string{"a.example.com"}},
+		&NewProxyResp{ProxyName: "web", RemoteAddr: "a.example.com"},
+		&NewWorkConn{ProxyName: "web"},
+		&CloseProxy{ProxyName: "web"},
+		&Ping{},
+		&Pong{},
+	}
+
+	for _, want := range cases {
+		var buf bytes.Buffer
+		if err := WriteMsg(&buf, want); err != nil {
+			t.Fatalf("WriteMsg(%T): %v", want, err)
+		}
+
+		got, err := ReadMsg(&buf)
+		if err != nil {
+			t.Fatalf("ReadMsg(%T): %v", want, err)
+		}
+		if got.typeID() != want.typeID() {
+			t.Fatalf("typeID mismatch: got %v, want %v", got.typeID(), want.typeID())
+		}
+	}
+}
+
+func TestReadMsgOversizedPayload(t *testing.T) {
+	header := make([]byte, 9)
+	header[0] = byte(TypePing)
+	binary.BigEndian.PutUint64(header[1:], maxPayloadSize+1)
+
+	if _, err := ReadMsg(bytes.NewReader(header)); err == nil {
+		t.Error("expected an error for a payload larger than maxPayloadSize")
+	}
+}
+
+func TestReadMsgUnknownType(t *testing.T) {
+	header := make([]byte, 9)
+	header[0] = 'Z'
+	binary.BigEndian.PutUint64(header[1:], 0)
+
+	if _, err := ReadMsg(bytes.NewReader(header)); err == nil {
+		t.Error("expected an error for an unknown message type")
+	}
+}
+
+func TestReadMsgTruncatedHeader(t *testing.T) {
+	if _, err := ReadMsg(bytes.NewReader([]byte{byte(TypePing), 0, 0})); err == nil {
+		t.Error("expected an error for a truncated header")
+	}
+}
+
+func TestReadMsgTruncatedPayload(t *testing.T) {
+	header := make([]byte, 9)
+	header[0] = byte(TypeLoginResp)
+	binary.BigEndian.PutUint64(header[1:], 10)
+	// Only 9 header bytes and no payload, but the header claims 10.
+	if _, err := ReadMsg(bytes.NewReader(header)); err == nil {
+		t.Error("expected an error when the payload is shorter than declared")
+	}
+}
@@ -1,27 +1,49 @@
 package main
 
 import (
+	"bufio"
 	"context"
 	"fmt"
 	"io"
 	"log"
 	"net"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"gopkg.in/yaml.v2"
 	"os"
+
+	"github.com/Ambitiousnoob/noobtunnel/auth"
+	"github.com/Ambitiousnoob/noobtunnel/dashboard"
+	"github.com/Ambitiousnoob/noobtunnel/metrics"
+	"github.com/Ambitiousnoob/noobtunnel/msg"
+	"github.com/Ambitiousnoob/noobtunnel/session"
+	"github.com/Ambitiousnoob/noobtunnel/vhost"
 )
 
+// heartbeatTimeout is how long the server waits for a Ping on a tunnel's
+// control stream before deciding the client is gone. heartbeatInterval
+// (the client's ping cadence) is defined in client.go. This replaces the
+// old configurable idle timeout entirely, since the client's heartbeat
+// now bounds how long a dead connection can go undetected.
+const heartbeatTimeout = 3 * heartbeatInterval
+
 type ServerConfig struct {
-	Port           int               `yaml:"port"`
-	MaxConnections int               `yaml:"max_connections"`
-	RateLimit      int               `yaml:"rate_limit"` // requests per minute
-	TimeoutMinutes int               `yaml:"timeout_minutes"`
-	AllowedPorts   []int             `yaml:"allowed_ports"`
-	BannedIPs      []string          `yaml:"banned_ips"`
-	LogLevel       string            `yaml:"log_level"`
-	Security       ServerSecurity    `yaml:"security"`
+	Port           int             `yaml:"port"`
+	MaxConnections int             `yaml:"max_connections"`
+	RateLimit      int             `yaml:"rate_limit"` // requests per minute
+	Tokens         []auth.TokenACL `yaml:"tokens"` // who may connect and what they may do; see auth.TokenACL
+	VhostHTTPPort  int             `yaml:"vhost_http_port"`
+	VhostHTTPSPort int             `yaml:"vhost_https_port"`
+	BannedIPs      []string        `yaml:"banned_ips"`
+	LogLevel       string          `yaml:"log_level"`
+	Security       ServerSecurity  `yaml:"security"`
+	DashboardAddr  string          `yaml:"dashboard_addr"`
+	DashboardPort  int             `yaml:"dashboard_port"`
+	DashboardUser  string          `yaml:"dashboard_user"`
+	DashboardPwd   string          `yaml:"dashboard_pwd"`
 }
 
 type ServerSecurity struct {
@@ -31,26 +53,40 @@ type ServerSecurity struct {
 }
 
 type Server struct {
-	config        *ServerConfig
-	listener      net.Listener
-	tunnels       map[int]*Tunnel
-	tunnelsMutex  sync.RWMutex
-	connections   map[string]int
-	connMutex     sync.RWMutex
-	rateLimiter   map[string]*RateLimit
-	rateMutex     sync.RWMutex
-	ctx           context.Context
-	cancel        context.CancelFunc
+	config            *ServerConfig
+	listener          net.Listener
+	authRegistry      *auth.Registry
+	tunnels           map[int]*Tunnel
+	tunnelsMutex      sync.RWMutex
+	connections       map[string]int
+	connMutex         sync.RWMutex
+	rateLimiter       map[string]*RateLimit
+	rateMutex         sync.RWMutex
+	tokenTunnelCounts map[string]int
+	tokenMutex        sync.Mutex
+	rejectedCounts    map[string]int64
+	rejectedMutex     sync.Mutex
+	rateLimitedCount  int64
+	vhostRouter       *vhost.Router
+	ctx               context.Context
+	cancel            context.CancelFunc
 }
 
 type Tunnel struct {
-	Port       int
-	Conn       net.Conn
-	Listener   net.Listener
-	ClientAddr string
-	CreatedAt  time.Time
-	ctx        context.Context
-	cancel     context.CancelFunc
+	Port          int
+	ProxyName     string
+	Session       *session.Session
+	Control       net.Conn
+	Listener      net.Listener
+	ClientAddr    string
+	ACL           *auth.TokenACL
+	CreatedAt     time.Time
+	BytesIn       int64 // atomic; bytes relayed from the public connection into the tunnel
+	BytesOut      int64 // atomic; bytes relayed from the tunnel back to the public connection
+	activeStreams int32 // atomic; public connections currently being relayed
+	ctx           context.Context
+	cancel        context.CancelFunc
+	closeOnce     sync.Once // guards cleanupTunnel against running twice for the same tunnel
 }
 
 type RateLimit struct {
@@ -63,8 +99,6 @@ func startServer(port int) {
 		Port:           port,
 		MaxConnections: 100,
 		RateLimit:      60, // 60 requests per minute
-		TimeoutMinutes: 30,
-		AllowedPorts:   []int{80, 8080, 3000, 3001, 8000, 8001, 9000},
 		LogLevel:       "info",
 		Security: ServerSecurity{
 			Enabled:            true,
@@ -95,15 +129,32 @@ func startServerWithConfig(configPath string) {
 func NewServer(config *ServerConfig) *Server {
 	ctx, cancel := context.WithCancel(context.Background())
 	return &Server{
-		config:      config,
-		tunnels:     make(map[int]*Tunnel),
-		connections: make(map[string]int),
-		rateLimiter: make(map[string]*RateLimit),
-		ctx:         ctx,
-		cancel:      cancel,
+		config:            config,
+		authRegistry:      newAuthRegistry(config),
+		tunnels:           make(map[int]*Tunnel),
+		connections:       make(map[string]int),
+		rateLimiter:       make(map[string]*RateLimit),
+		tokenTunnelCounts: make(map[string]int),
+		rejectedCounts:    make(map[string]int64),
+		vhostRouter:       vhost.NewRouter(),
+		ctx:               ctx,
+		cancel:            cancel,
 	}
 }
 
+// newAuthRegistry builds the server's token registry from config, falling
+// back to a single unnamed token read from NTUNNEL_TOKEN so a deployment
+// doesn't have to write a config file just to set one shared secret.
+func newAuthRegistry(config *ServerConfig) *auth.Registry {
+	tokens := config.Tokens
+	if len(tokens) == 0 {
+		if envToken := os.Getenv("NTUNNEL_TOKEN"); envToken != "" {
+			tokens = []auth.TokenACL{{Name: "default", Token: envToken}}
+		}
+	}
+	return auth.NewRegistry(tokens)
+}
+
 func (s *Server) Start() {
 	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", s.config.Port))
 	if err != nil {
@@ -112,11 +163,26 @@ func (s *Server) Start() {
 	s.listener = listener
 
 	fmt.Printf("🚀 NoobTunnel Server started on port %d\n", s.config.Port)
-	fmt.Printf("🔒 Security: %v | Max Connections: %d | Rate Limit: %d/min\n", 
+	fmt.Printf("🔒 Security: %v | Max Connections: %d | Rate Limit: %d/min\n",
 		s.config.Security.Enabled, s.config.MaxConnections, s.config.RateLimit)
-	fmt.Printf("🎯 Allowed Ports: %v\n", s.config.AllowedPorts)
+	if s.authRegistry.Len() > 0 {
+		fmt.Printf("🔑 %d token(s) configured\n", s.authRegistry.Len())
+	} else {
+		fmt.Println("⚠️ No tokens configured, accepting clients without authentication")
+	}
 	fmt.Println("📡 Waiting for client connections...")
 
+	if s.config.VhostHTTPPort != 0 {
+		go s.startVhostListener(s.config.VhostHTTPPort, false)
+	}
+	if s.config.VhostHTTPSPort != 0 {
+		go s.startVhostListener(s.config.VhostHTTPSPort, true)
+	}
+
+	if s.config.DashboardPort != 0 {
+		go s.startDashboard()
+	}
+
 	// Start cleanup routine
 	go s.cleanupRoutine()
 
@@ -148,6 +214,7 @@ func (s *Server) handleConnection(conn net.Conn) {
 
 	// Security checks
 	if !s.checkSecurity(host) {
+		s.rejectConnection("security")
 		log.Printf("🚫 Connection rejected from %s (security check failed)", host)
 		return
 	}
@@ -165,26 +232,65 @@ func (s *Server) handleConnection(conn net.Conn) {
 
 	log.Printf("✅ New connection from %s (total: %d)", clientAddr, connCount)
 
-	// Read tunnel request
-	buffer := make([]byte, 1024)
-	conn.SetReadDeadline(time.Now().Add(30 * time.Second))
-	n, err := conn.Read(buffer)
+	// The per-host slot claimed above is handed off to cleanupTunnel or
+	// handleHTTPTunnelRequest once a tunnel exists; every earlier return
+	// gives it back here.
+	handedOff := false
+	defer func() {
+		if !handedOff {
+			s.releaseConnection(clientAddr)
+		}
+	}()
+
+	// Every client connection is a multiplexed session; the client always
+	// opens the control stream first, so accepting once here gives us the
+	// control channel before any data stream can exist.
+	sess, err := session.Server(conn)
 	if err != nil {
-		log.Printf("⚠️ Failed to read from client %s: %v", clientAddr, err)
+		log.Printf("⚠️ Failed to establish session with %s: %v", clientAddr, err)
 		return
 	}
+	defer sess.Close()
 
-	request := string(buffer[:n])
-	var remotePort int
-	if _, err := fmt.Sscanf(request, "TUNNEL %d", &remotePort); err != nil {
-		log.Printf("⚠️ Invalid tunnel request from %s: %s", clientAddr, request)
+	control, err := sess.AcceptStream()
+	if err != nil {
+		log.Printf("⚠️ Failed to accept control stream from %s: %v", clientAddr, err)
+		return
+	}
+
+	acl, err := s.authenticate(control, clientAddr)
+	if err != nil {
+		s.rejectConnection("auth_failed")
+		log.Printf("⚠️ %v", err)
 		return
 	}
 
+	proxy, err := s.readNewProxy(control, clientAddr)
+	if err != nil {
+		log.Printf("⚠️ %v", err)
+		return
+	}
+
+	if !s.acquireTunnelSlot(acl) {
+		s.rejectConnection("max_tunnels")
+		msg.WriteMsg(control, &msg.NewProxyResp{ProxyName: proxy.ProxyName, Error: "max_tunnels reached for this token"})
+		log.Printf("🚫 Token %q at max_tunnels, request from %s denied", tokenName(acl), clientAddr)
+		return
+	}
+
+	if proxy.ProxyType == "http" {
+		handedOff = true
+		s.handleHTTPTunnelRequest(sess, control, proxy, clientAddr, acl)
+		return
+	}
+
+	remotePort := proxy.RemotePort
+
 	// Check if port is allowed
-	if !s.isPortAllowed(remotePort) {
-		response := fmt.Sprintf("ERROR Port %d not allowed", remotePort)
-		conn.Write([]byte(response))
+	if !portAllowed(acl, remotePort) {
+		s.releaseTunnelSlot(acl)
+		s.rejectConnection("port_denied")
+		msg.WriteMsg(control, &msg.NewProxyResp{ProxyName: proxy.ProxyName, Error: fmt.Sprintf("port %d not allowed", remotePort)})
 		log.Printf("🚫 Port %d not allowed for %s", remotePort, clientAddr)
 		return
 	}
@@ -193,31 +299,303 @@ func (s *Server) handleConnection(conn net.Conn) {
 	s.tunnelsMutex.Lock()
 	if existingTunnel, exists := s.tunnels[remotePort]; exists {
 		s.tunnelsMutex.Unlock()
-		response := fmt.Sprintf("ERROR Port %d already in use by %s", remotePort, existingTunnel.ClientAddr)
-		conn.Write([]byte(response))
+		s.releaseTunnelSlot(acl)
+		s.rejectConnection("port_in_use")
+		msg.WriteMsg(control, &msg.NewProxyResp{ProxyName: proxy.ProxyName, Error: fmt.Sprintf("port %d already in use by %s", remotePort, existingTunnel.ClientAddr)})
 		log.Printf("⚠️ Port %d already in use, request from %s denied", remotePort, clientAddr)
 		return
 	}
 	s.tunnelsMutex.Unlock()
 
 	// Create tunnel
-	tunnel, err := s.createTunnel(remotePort, conn, clientAddr)
+	tunnel, err := s.createTunnel(remotePort, proxy.ProxyName, sess, control, clientAddr, acl)
 	if err != nil {
-		response := fmt.Sprintf("ERROR %s", err.Error())
-		conn.Write([]byte(response))
+		s.releaseTunnelSlot(acl)
+		s.rejectConnection("tunnel_error")
+		msg.WriteMsg(control, &msg.NewProxyResp{ProxyName: proxy.ProxyName, Error: err.Error()})
 		log.Printf("❌ Failed to create tunnel for %s: %v", clientAddr, err)
 		return
 	}
 
-	response := fmt.Sprintf("OK Tunnel established on port %d", remotePort)
-	conn.Write([]byte(response))
+	handedOff = true
+	msg.WriteMsg(control, &msg.NewProxyResp{ProxyName: proxy.ProxyName, RemoteAddr: fmt.Sprintf(":%d", remotePort)})
 	log.Printf("🎯 Tunnel created: %s -> port %d", clientAddr, remotePort)
 
 	// Keep connection alive and handle tunnel
 	s.handleTunnel(tunnel)
 }
 
-func (s *Server) createTunnel(port int, clientConn net.Conn, clientAddr string) (*Tunnel, error) {
+// authenticate runs the nonce/HMAC challenge-response handshake described
+// by the auth package and replies with a LoginResp either way. When no
+// tokens are configured, the handshake still happens (so the wire
+// protocol doesn't fork), but any signature is accepted.
+func (s *Server) authenticate(control net.Conn, clientAddr string) (*auth.TokenACL, error) {
+	nonce, err := auth.NewNonce()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate auth challenge for %s: %v", clientAddr, err)
+	}
+	if err := msg.WriteMsg(control, &msg.AuthChallenge{Nonce: nonce}); err != nil {
+		return nil, fmt.Errorf("failed to send auth challenge to %s: %v", clientAddr, err)
+	}
+
+	control.SetReadDeadline(time.Now().Add(30 * time.Second))
+	m, err := msg.ReadMsg(control)
+	control.SetReadDeadline(time.Time{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read login from %s: %v", clientAddr, err)
+	}
+	login, ok := m.(*msg.Login)
+	if !ok {
+		return nil, fmt.Errorf("expected login from %s, got %T", clientAddr, m)
+	}
+
+	if login.ProtoVersion != msg.ProtoVersion {
+		errMsg := fmt.Sprintf("unsupported protocol version %d", login.ProtoVersion)
+		msg.WriteMsg(control, &msg.LoginResp{Error: errMsg})
+		return nil, fmt.Errorf("rejected login from %s: %s", clientAddr, errMsg)
+	}
+
+	var acl *auth.TokenACL
+	if s.authRegistry.Len() > 0 {
+		acl, err = s.authRegistry.Authenticate(nonce, login.Timestamp, login.Signature, time.Now())
+		if err != nil {
+			msg.WriteMsg(control, &msg.LoginResp{Error: "authentication failed"})
+			return nil, fmt.Errorf("authentication failed for %s: %v", clientAddr, err)
+		}
+	}
+
+	if err := msg.WriteMsg(control, &msg.LoginResp{Version: Version}); err != nil {
+		return nil, fmt.Errorf("failed to send login response to %s: %v", clientAddr, err)
+	}
+	return acl, nil
+}
+
+// acquireTunnelSlot reports whether acl still has room for another tunnel
+// under its max_tunnels limit, reserving the slot if so. A nil acl (no
+// tokens configured) or a MaxTunnels of 0 means unlimited.
+func (s *Server) acquireTunnelSlot(acl *auth.TokenACL) bool {
+	if acl == nil || acl.MaxTunnels <= 0 {
+		return true
+	}
+
+	s.tokenMutex.Lock()
+	defer s.tokenMutex.Unlock()
+	if s.tokenTunnelCounts[acl.Name] >= acl.MaxTunnels {
+		return false
+	}
+	s.tokenTunnelCounts[acl.Name]++
+	return true
+}
+
+// releaseTunnelSlot gives back a slot reserved by acquireTunnelSlot, e.g.
+// after a registration attempt fails or a tunnel is cleaned up.
+func (s *Server) releaseTunnelSlot(acl *auth.TokenACL) {
+	if acl == nil {
+		return
+	}
+
+	s.tokenMutex.Lock()
+	defer s.tokenMutex.Unlock()
+	if s.tokenTunnelCounts[acl.Name] > 0 {
+		s.tokenTunnelCounts[acl.Name]--
+	}
+}
+
+func tokenName(acl *auth.TokenACL) string {
+	if acl == nil {
+		return "(none)"
+	}
+	return acl.Name
+}
+
+// readNewProxy reads the NewProxy handshake message that follows a
+// successful login.
+func (s *Server) readNewProxy(control net.Conn, clientAddr string) (*msg.NewProxy, error) {
+	control.SetReadDeadline(time.Now().Add(30 * time.Second))
+	defer control.SetReadDeadline(time.Time{})
+
+	m, err := msg.ReadMsg(control)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read new proxy request from %s: %v", clientAddr, err)
+	}
+	proxy, ok := m.(*msg.NewProxy)
+	if !ok {
+		return nil, fmt.Errorf("expected new proxy request from %s, got %T", clientAddr, m)
+	}
+	return proxy, nil
+}
+
+// handleHTTPTunnelRequest registers one or more hostnames from an
+// "http"-type tunnel with the vhost router instead of opening a dedicated
+// port listener, then blocks for the lifetime of the control stream so the
+// hostnames are released as soon as the client disconnects.
+func (s *Server) handleHTTPTunnelRequest(sess *session.Session, control net.Conn, proxy *msg.NewProxy, clientAddr string, acl *auth.TokenACL) {
+	defer s.releaseTunnelSlot(acl)
+	defer s.releaseConnection(clientAddr)
+
+	domains := proxy.CustomDomains
+
+	for _, domain := range domains {
+		if domain == "" || !domainAllowed(acl, domain) {
+			s.rejectConnection("domain_denied")
+			msg.WriteMsg(control, &msg.NewProxyResp{ProxyName: proxy.ProxyName, Error: fmt.Sprintf("domain %s not allowed", domain)})
+			log.Printf("🚫 Domain %s not allowed for %s", domain, clientAddr)
+			return
+		}
+	}
+
+	reg := &vhost.Registration{Session: sess, ClientAddr: clientAddr, ProxyName: proxy.ProxyName}
+	registered := make([]string, 0, len(domains))
+	for _, domain := range domains {
+		if err := s.vhostRouter.Register(domain, reg); err != nil {
+			for _, d := range registered {
+				s.vhostRouter.Unregister(d)
+			}
+			msg.WriteMsg(control, &msg.NewProxyResp{ProxyName: proxy.ProxyName, Error: err.Error()})
+			log.Printf("⚠️ Failed to register domain %s for %s: %v", domain, clientAddr, err)
+			return
+		}
+		registered = append(registered, domain)
+	}
+	defer func() {
+		for _, domain := range registered {
+			s.vhostRouter.Unregister(domain)
+		}
+	}()
+
+	msg.WriteMsg(control, &msg.NewProxyResp{ProxyName: proxy.ProxyName, RemoteAddr: strings.Join(domains, ", ")})
+	log.Printf("🎯 HTTP tunnel created: %s -> %s", clientAddr, strings.Join(domains, ", "))
+
+	for {
+		control.SetReadDeadline(time.Now().Add(heartbeatTimeout))
+		m, err := msg.ReadMsg(control)
+		control.SetReadDeadline(time.Time{})
+		if err != nil {
+			log.Printf("🔌 Client disconnected from HTTP tunnel %s (%s)", strings.Join(domains, ", "), clientAddr)
+			return
+		}
+
+		switch m.(type) {
+		case *msg.Ping:
+			if err := msg.WriteMsg(control, &msg.Pong{}); err != nil {
+				log.Printf("⚠️ Failed to send pong to %s: %v", clientAddr, err)
+				return
+			}
+		case *msg.CloseProxy:
+			log.Printf("🔌 Client closed HTTP tunnel %s (%s)", strings.Join(domains, ", "), clientAddr)
+			return
+		}
+	}
+}
+
+// domainAllowed reports whether host may be claimed as a vhost domain
+// under acl. An empty AllowedSubdomains list (or a nil acl, meaning no
+// tokens are configured) allows any host. Entries may be exact hostnames
+// or "*.suffix" wildcards.
+func domainAllowed(acl *auth.TokenACL, host string) bool {
+	if acl == nil || len(acl.AllowedSubdomains) == 0 {
+		return true
+	}
+
+	host = strings.ToLower(host)
+	for _, allowed := range acl.AllowedSubdomains {
+		allowed = strings.ToLower(allowed)
+		if strings.HasPrefix(allowed, "*.") {
+			if strings.HasSuffix(host, allowed[1:]) {
+				return true
+			}
+			continue
+		}
+		if host == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// startVhostListener runs a single shared listener for either the HTTP or
+// HTTPS vhost port, sniffing each connection's target hostname and handing
+// it off to the matching client's tunnel session.
+func (s *Server) startVhostListener(port int, isTLS bool) {
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		log.Fatalf("❌ Failed to start vhost listener on port %d: %v", port, err)
+	}
+
+	kind := "HTTP"
+	if isTLS {
+		kind = "HTTPS"
+	}
+	fmt.Printf("🌐 Vhost %s listener started on port %d\n", kind, port)
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			select {
+			case <-s.ctx.Done():
+				return
+			default:
+				log.Printf("⚠️ Failed to accept vhost connection: %v", err)
+				continue
+			}
+		}
+
+		go s.handleVhostConnection(conn, isTLS)
+	}
+}
+
+func (s *Server) handleVhostConnection(conn net.Conn, isTLS bool) {
+	defer conn.Close()
+
+	// The vhost sniffers peek up to MaxHeaderPeek bytes looking for the Host
+	// header or TLS SNI extension; a bufio.Reader can never Peek past its own
+	// buffer size, so it must be sized to match or large ClientHellos/requests
+	// truncate silently.
+	reader := bufio.NewReaderSize(conn, vhost.MaxHeaderPeek)
+
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	var (
+		host string
+		err  error
+	)
+	if isTLS {
+		host, err = vhost.PeekTLSServerName(reader)
+	} else {
+		host, err = vhost.PeekHTTPHost(reader)
+	}
+	conn.SetReadDeadline(time.Time{})
+	if err != nil {
+		log.Printf("⚠️ Failed to determine vhost target from %s: %v", conn.RemoteAddr(), err)
+		return
+	}
+
+	reg, ok := s.vhostRouter.Lookup(host)
+	if !ok {
+		log.Printf("🚫 No tunnel registered for host %q", host)
+		return
+	}
+
+	stream, err := reg.Session.OpenStream()
+	if err != nil {
+		log.Printf("⚠️ Failed to open stream for host %q: %v", host, err)
+		return
+	}
+	defer stream.Close()
+
+	if err := msg.WriteMsg(stream, &msg.NewWorkConn{ProxyName: reg.ProxyName}); err != nil {
+		log.Printf("⚠️ Failed to send work conn header for host %q: %v", host, err)
+		return
+	}
+
+	go func() {
+		io.Copy(stream, reader)
+		stream.Close()
+	}()
+
+	io.Copy(conn, stream)
+}
+
+func (s *Server) createTunnel(port int, proxyName string, sess *session.Session, control net.Conn, clientAddr string, acl *auth.TokenACL) (*Tunnel, error) {
 	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
 	if err != nil {
 		return nil, fmt.Errorf("failed to listen on port %d: %v", port, err)
@@ -226,9 +604,12 @@ func (s *Server) createTunnel(port int, clientConn net.Conn, clientAddr string)
 	ctx, cancel := context.WithCancel(s.ctx)
 	tunnel := &Tunnel{
 		Port:       port,
-		Conn:       clientConn,
+		ProxyName:  proxyName,
+		Session:    sess,
+		Control:    control,
 		Listener:   listener,
 		ClientAddr: clientAddr,
+		ACL:        acl,
 		CreatedAt:  time.Now(),
 		ctx:        ctx,
 		cancel:     cancel,
@@ -270,27 +651,38 @@ func (s *Server) acceptTunnelConnections(tunnel *Tunnel) {
 func (s *Server) handleTunnelConnection(tunnel *Tunnel, publicConn net.Conn) {
 	defer publicConn.Close()
 
-	// Send connection signal to client
-	signal := fmt.Sprintf("CONN %d", tunnel.Port)
-	if _, err := tunnel.Conn.Write([]byte(signal)); err != nil {
-		log.Printf("⚠️ Failed to signal client for port %d: %v", tunnel.Port, err)
+	// Open a fresh logical stream for this public connection so it never
+	// shares bytes with the control stream or any other public connection
+	// on the same tunnel.
+	stream, err := tunnel.Session.OpenStream()
+	if err != nil {
+		log.Printf("⚠️ Failed to open stream for port %d: %v", tunnel.Port, err)
+		return
+	}
+	defer stream.Close()
+
+	if err := msg.WriteMsg(stream, &msg.NewWorkConn{ProxyName: tunnel.ProxyName}); err != nil {
+		log.Printf("⚠️ Failed to send work conn header for port %d: %v", tunnel.Port, err)
 		return
 	}
 
-	// Relay data between public connection and tunnel connection
+	atomic.AddInt32(&tunnel.activeStreams, 1)
+	defer atomic.AddInt32(&tunnel.activeStreams, -1)
+
+	// Relay data between public connection and tunnel stream, counting
+	// bytes as they flow so the dashboard reflects long-lived connections
+	// in real time rather than only once they close.
 	go func() {
-		io.Copy(tunnel.Conn, publicConn)
-		publicConn.Close()
+		io.Copy(metrics.NewCountingWriter(stream, &tunnel.BytesIn), publicConn)
+		stream.Close()
 	}()
 
-	io.Copy(publicConn, tunnel.Conn)
+	io.Copy(metrics.NewCountingWriter(publicConn, &tunnel.BytesOut), stream)
 }
 
 func (s *Server) handleTunnel(tunnel *Tunnel) {
 	defer s.cleanupTunnel(tunnel)
 
-	// Keep connection alive
-	buffer := make([]byte, 1024)
 	for {
 		select {
 		case <-tunnel.ctx.Done():
@@ -298,30 +690,61 @@ func (s *Server) handleTunnel(tunnel *Tunnel) {
 		default:
 		}
 
-		tunnel.Conn.SetReadDeadline(time.Now().Add(time.Duration(s.config.TimeoutMinutes) * time.Minute))
-		_, err := tunnel.Conn.Read(buffer)
+		tunnel.Control.SetReadDeadline(time.Now().Add(heartbeatTimeout))
+		m, err := msg.ReadMsg(tunnel.Control)
+		tunnel.Control.SetReadDeadline(time.Time{})
 		if err != nil {
 			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
-				log.Printf("⏰ Tunnel timeout for port %d from %s", tunnel.Port, tunnel.ClientAddr)
+				log.Printf("⏰ Tunnel heartbeat timeout for port %d from %s", tunnel.Port, tunnel.ClientAddr)
 			} else {
 				log.Printf("🔌 Client disconnected from port %d (%s)", tunnel.Port, tunnel.ClientAddr)
 			}
 			return
 		}
+
+		switch m.(type) {
+		case *msg.Ping:
+			if err := msg.WriteMsg(tunnel.Control, &msg.Pong{}); err != nil {
+				log.Printf("⚠️ Failed to send pong to %s: %v", tunnel.ClientAddr, err)
+				return
+			}
+		case *msg.CloseProxy:
+			log.Printf("🔌 Client closed tunnel on port %d (%s)", tunnel.Port, tunnel.ClientAddr)
+			return
+		}
 	}
 }
 
+// cleanupTunnel tears down tunnel and releases the slots it held. It's
+// called both by handleTunnel's own goroutine on disconnect and by
+// CloseTunnel when the dashboard force-closes a tunnel, and closing the
+// session in the latter case unblocks the former's read loop into the
+// same call — closeOnce makes sure the (non-idempotent) slot/connection
+// accounting below only ever runs once per tunnel.
 func (s *Server) cleanupTunnel(tunnel *Tunnel) {
-	tunnel.cancel()
-	tunnel.Listener.Close()
-	tunnel.Conn.Close()
+	tunnel.closeOnce.Do(func() {
+		tunnel.cancel()
+		tunnel.Listener.Close()
+		tunnel.Session.Close()
+		s.releaseTunnelSlot(tunnel.ACL)
+
+		s.tunnelsMutex.Lock()
+		delete(s.tunnels, tunnel.Port)
+		s.tunnelsMutex.Unlock()
 
-	s.tunnelsMutex.Lock()
-	delete(s.tunnels, tunnel.Port)
-	s.tunnelsMutex.Unlock()
+		s.releaseConnection(tunnel.ClientAddr)
+
+		log.Printf("🧹 Tunnel cleaned up: port %d from %s", tunnel.Port, tunnel.ClientAddr)
+	})
+}
 
-	// Update connection count
-	host, _, _ := net.SplitHostPort(tunnel.ClientAddr)
+// releaseConnection gives back a per-host connection slot claimed in
+// handleConnection, e.g. after a tunnel is cleaned up or an HTTP tunnel's
+// control loop exits. Every path that increments s.connections[host] must
+// have a matching call to this, or checkSecurity's MaxConnectionsPerIP
+// check will eventually lock the host out permanently.
+func (s *Server) releaseConnection(clientAddr string) {
+	host, _, _ := net.SplitHostPort(clientAddr)
 	s.connMutex.Lock()
 	if s.connections[host] > 0 {
 		s.connections[host]--
@@ -330,8 +753,6 @@ func (s *Server) cleanupTunnel(tunnel *Tunnel) {
 		delete(s.connections, host)
 	}
 	s.connMutex.Unlock()
-
-	log.Printf("🧹 Tunnel cleaned up: port %d from %s", tunnel.Port, tunnel.ClientAddr)
 }
 
 func (s *Server) checkSecurity(host string) bool {
@@ -378,6 +799,7 @@ func (s *Server) checkRateLimit(host string) bool {
 	}
 
 	if rate.Requests >= s.config.Security.RateLimitPerIP {
+		atomic.AddInt64(&s.rateLimitedCount, 1)
 		return false
 	}
 
@@ -385,12 +807,14 @@ func (s *Server) checkRateLimit(host string) bool {
 	return true
 }
 
-func (s *Server) isPortAllowed(port int) bool {
-	if len(s.config.AllowedPorts) == 0 {
+// portAllowed reports whether acl may claim port. An empty AllowedPorts
+// list (or a nil acl, meaning no tokens are configured) allows any port.
+func portAllowed(acl *auth.TokenACL, port int) bool {
+	if acl == nil || len(acl.AllowedPorts) == 0 {
 		return true
 	}
 
-	for _, allowedPort := range s.config.AllowedPorts {
+	for _, allowedPort := range acl.AllowedPorts {
 		if port == allowedPort {
 			return true
 		}
@@ -398,6 +822,87 @@ func (s *Server) isPortAllowed(port int) bool {
 	return false
 }
 
+// startDashboard runs the operator-facing HTTP API and Prometheus
+// /metrics endpoint until the process exits; it does not participate in
+// s.ctx shutdown since it serves read-only diagnostics, not tunnels.
+func (s *Server) startDashboard() {
+	addr := fmt.Sprintf("%s:%d", s.config.DashboardAddr, s.config.DashboardPort)
+	fmt.Printf("📊 Dashboard listening on %s\n", addr)
+
+	dash := dashboard.New(s, s.config.DashboardUser, s.config.DashboardPwd)
+	if err := dash.Start(addr); err != nil {
+		log.Printf("⚠️ Dashboard stopped: %v", err)
+	}
+}
+
+// Tunnels implements dashboard.Source. It only reports TCP port tunnels
+// (s.tunnels); HTTP/HTTPS vhost tunnels registered with s.vhostRouter have
+// no BytesIn/BytesOut/stream tracking of their own and don't appear here or
+// in the per-tunnel Prometheus metrics. Rejected vhost registrations are
+// still counted in ConnectionsRejected.
+func (s *Server) Tunnels() []dashboard.TunnelInfo {
+	s.tunnelsMutex.RLock()
+	defer s.tunnelsMutex.RUnlock()
+
+	infos := make([]dashboard.TunnelInfo, 0, len(s.tunnels))
+	for _, t := range s.tunnels {
+		infos = append(infos, dashboard.TunnelInfo{
+			Port:       t.Port,
+			ProxyName:  t.ProxyName,
+			ClientAddr: t.ClientAddr,
+			CreatedAt:  t.CreatedAt,
+			BytesIn:    atomic.LoadInt64(&t.BytesIn),
+			BytesOut:   atomic.LoadInt64(&t.BytesOut),
+			Streams:    int(atomic.LoadInt32(&t.activeStreams)),
+		})
+	}
+	return infos
+}
+
+// CloseTunnel implements dashboard.Source.
+func (s *Server) CloseTunnel(port int) bool {
+	s.tunnelsMutex.RLock()
+	tunnel, exists := s.tunnels[port]
+	s.tunnelsMutex.RUnlock()
+	if !exists {
+		return false
+	}
+
+	// Give the client a graceful heads-up before slamming the session shut,
+	// so its control loop exits on CloseProxy rather than an ambiguous read
+	// error. Best-effort: the client may already be gone.
+	msg.WriteMsg(tunnel.Control, &msg.CloseProxy{ProxyName: tunnel.ProxyName})
+
+	s.cleanupTunnel(tunnel)
+	return true
+}
+
+// ConnectionsRejected implements dashboard.Source.
+func (s *Server) ConnectionsRejected() map[string]int64 {
+	s.rejectedMutex.Lock()
+	defer s.rejectedMutex.Unlock()
+
+	snapshot := make(map[string]int64, len(s.rejectedCounts))
+	for reason, count := range s.rejectedCounts {
+		snapshot[reason] = count
+	}
+	return snapshot
+}
+
+// RateLimited implements dashboard.Source.
+func (s *Server) RateLimited() int64 {
+	return atomic.LoadInt64(&s.rateLimitedCount)
+}
+
+// rejectConnection records a connection or registration turned away
+// before a tunnel existed for it, surfaced as
+// noobtunnel_connections_rejected_total{reason} on the dashboard.
+func (s *Server) rejectConnection(reason string) {
+	s.rejectedMutex.Lock()
+	s.rejectedCounts[reason]++
+	s.rejectedMutex.Unlock()
+}
+
 func (s *Server) cleanupRoutine() {
 	ticker := time.NewTicker(5 * time.Minute)
 	defer ticker.Stop()
@@ -415,17 +920,6 @@ func (s *Server) cleanupRoutine() {
 				}
 			}
 			s.rateMutex.Unlock()
-
-			// Log active tunnels
-			s.tunnelsMutex.RLock()
-			activeTunnels := len(s.tunnels)
-			s.tunnelsMutex.RUnlock()
-
-			s.connMutex.RLock()
-			activeIPs := len(s.connections)
-			s.connMutex.RUnlock()
-
-			log.Printf("📊 Status: %d active tunnels, %d unique IPs connected", activeTunnels, activeIPs)
 		}
 	}
-}
\ No newline at end of file
+}
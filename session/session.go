@@ -0,0 +1,73 @@
+// Package session provides the multiplexed client/server transport used by
+// the tunnel. A single TCP connection between client and server is wrapped
+// in a yamux session so that control messages and each public connection's
+// data get their own logical stream instead of sharing one socket. The
+// client always opens the first stream (the control stream) and the server
+// always accepts it first, so stream #0 is the control channel by
+// construction on both ends; every stream opened after that carries one
+// public connection's traffic.
+package session
+
+import (
+	"net"
+
+	"github.com/hashicorp/yamux"
+)
+
+// Session wraps a yamux.Session so the rest of the codebase only depends on
+// the small surface it actually needs.
+type Session struct {
+	ym *yamux.Session
+}
+
+// config mirrors yamux's defaults but disables its built-in keepalive; the
+// control protocol is responsible for liveness checks instead.
+func config() *yamux.Config {
+	cfg := yamux.DefaultConfig()
+	cfg.EnableKeepAlive = false
+	return cfg
+}
+
+// Client wraps conn as the client side of a multiplexed session.
+func Client(conn net.Conn) (*Session, error) {
+	ym, err := yamux.Client(conn, config())
+	if err != nil {
+		return nil, err
+	}
+	return &Session{ym: ym}, nil
+}
+
+// Server wraps conn as the server side of a multiplexed session.
+func Server(conn net.Conn) (*Session, error) {
+	ym, err := yamux.Server(conn, config())
+	if err != nil {
+		return nil, err
+	}
+	return &Session{ym: ym}, nil
+}
+
+// OpenStream opens a new logical stream over the session.
+func (s *Session) OpenStream() (net.Conn, error) {
+	return s.ym.OpenStream()
+}
+
+// AcceptStream blocks until the peer opens a new logical stream.
+func (s *Session) AcceptStream() (net.Conn, error) {
+	return s.ym.AcceptStream()
+}
+
+// Close tears down every stream on the session along with the underlying
+// connection.
+func (s *Session) Close() error {
+	return s.ym.Close()
+}
+
+// IsClosed reports whether the session has already been torn down.
+func (s *Session) IsClosed() bool {
+	select {
+	case <-s.ym.CloseChan():
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,85 @@
+package session
+
+import (
+	"net"
+	"testing"
+)
+
+func TestClientServerStreamRoundTrip(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+
+	serverDone := make(chan error, 1)
+	var server *Session
+	go func() {
+		var err error
+		server, err = Server(serverConn)
+		serverDone <- err
+	}()
+
+	client, err := Client(clientConn)
+	if err != nil {
+		t.Fatalf("Client: %v", err)
+	}
+	defer client.Close()
+
+	if err := <-serverDone; err != nil {
+		t.Fatalf("Server: %v", err)
+	}
+	defer server.Close()
+
+	// The client always opens the first stream and the server always
+	// accepts it first, mirroring how the control stream is established.
+	acceptErr := make(chan error, 1)
+	var serverStream net.Conn
+	go func() {
+		var err error
+		serverStream, err = server.AcceptStream()
+		acceptErr <- err
+	}()
+
+	clientStream, err := client.OpenStream()
+	if err != nil {
+		t.Fatalf("OpenStream: %v", err)
+	}
+	defer clientStream.Close()
+
+	if err := <-acceptErr; err != nil {
+		t.Fatalf("AcceptStream: %v", err)
+	}
+	defer serverStream.Close()
+
+	want := []byte("hello over yamux")
+	if _, err := clientStream.Write(want); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	got := make([]byte, len(want))
+	if _, err := serverStream.Read(got); err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	if string(got) != string(want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestIsClosed(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	defer serverConn.Close()
+
+	client, err := Client(clientConn)
+	if err != nil {
+		t.Fatalf("Client: %v", err)
+	}
+
+	if client.IsClosed() {
+		t.Fatal("session reported closed before Close was called")
+	}
+
+	if err := client.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	if !client.IsClosed() {
+		t.Error("session did not report closed after Close")
+	}
+}
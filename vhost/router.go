@@ -0,0 +1,73 @@
+// Package vhost lets many client sessions share a single public HTTP or
+// HTTPS port. The server sniffs the Host header (or TLS SNI) off of each
+// incoming connection before any bytes are consumed by a handshake, looks
+// it up in a Router, and relays the raw connection to whichever client
+// session registered that hostname.
+package vhost
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/Ambitiousnoob/noobtunnel/session"
+)
+
+// Registration is the client session a hostname has been claimed for.
+type Registration struct {
+	Session    *session.Session
+	ClientAddr string
+	ProxyName  string
+}
+
+// Router maps hostnames to the Registration that should handle them.
+type Router struct {
+	mu      sync.RWMutex
+	targets map[string]*Registration
+}
+
+// NewRouter returns an empty Router.
+func NewRouter() *Router {
+	return &Router{targets: make(map[string]*Registration)}
+}
+
+// Register claims host for reg. It fails if the hostname is already
+// claimed, even by reg itself.
+func (r *Router) Register(host string, reg *Registration) error {
+	host = normalizeHost(host)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.targets[host]; exists {
+		return fmt.Errorf("host %q is already registered", host)
+	}
+	r.targets[host] = reg
+	return nil
+}
+
+// Unregister releases host so it can be claimed again.
+func (r *Router) Unregister(host string) {
+	host = normalizeHost(host)
+
+	r.mu.Lock()
+	delete(r.targets, host)
+	r.mu.Unlock()
+}
+
+// Lookup returns the registration claiming host, if any.
+func (r *Router) Lookup(host string) (*Registration, bool) {
+	host = normalizeHost(host)
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	reg, ok := r.targets[host]
+	return reg, ok
+}
+
+func normalizeHost(host string) string {
+	if i := strings.IndexByte(host, ':'); i != -1 {
+		host = host[:i]
+	}
+	return strings.ToLower(host)
+}
@@ -0,0 +1,140 @@
+package vhost
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net/textproto"
+)
+
+// MaxHeaderPeek bounds how many bytes we'll buffer while looking for the
+// Host header or TLS SNI extension, so a client can't force us to hold an
+// unbounded amount of unparsed data before routing decides where it goes.
+// Callers must construct their bufio.Reader with at least this size, since
+// bufio.Reader.Peek can never return more than its own buffer holds.
+const MaxHeaderPeek = 8192
+
+// PeekHTTPHost reads far enough into r to find the Host header of an HTTP
+// request without consuming any bytes, so the full request can still be
+// relayed byte-for-byte to the backend afterwards.
+func PeekHTTPHost(r *bufio.Reader) (string, error) {
+	// Grow the peek one read at a time and stop at the blank line ending the
+	// header block, so a client waiting for a response isn't stalled until
+	// MaxHeaderPeek bytes arrive.
+	var (
+		buf     []byte
+		peekErr error
+	)
+	for {
+		buf, _ = r.Peek(r.Buffered())
+		if bytes.Contains(buf, []byte("\r\n\r\n")) || len(buf) >= MaxHeaderPeek {
+			break
+		}
+		if buf, peekErr = r.Peek(len(buf) + 1); peekErr != nil {
+			break
+		}
+	}
+	if len(buf) == 0 {
+		return "", peekErr
+	}
+
+	tp := textproto.NewReader(bufio.NewReader(bytes.NewReader(buf)))
+	if _, err := tp.ReadLine(); err != nil {
+		return "", fmt.Errorf("read request line: %v", err)
+	}
+
+	headers, err := tp.ReadMIMEHeader()
+	host := headers.Get("Host")
+	if host == "" {
+		if err != nil {
+			return "", fmt.Errorf("read headers: %v", err)
+		}
+		return "", fmt.Errorf("no Host header present")
+	}
+	return host, nil
+}
+
+// PeekTLSServerName reads far enough into r to find the SNI server_name
+// extension of a TLS ClientHello without consuming any bytes, so the raw
+// handshake can still be relayed byte-for-byte to the backend afterwards.
+func PeekTLSServerName(r *bufio.Reader) (string, error) {
+	header, err := r.Peek(5)
+	if err != nil {
+		return "", err
+	}
+	if header[0] != 0x16 {
+		return "", fmt.Errorf("not a TLS handshake record")
+	}
+
+	recordLen := int(header[3])<<8 | int(header[4])
+	record, err := r.Peek(5 + recordLen)
+	if err != nil {
+		return "", err
+	}
+
+	return parseClientHelloSNI(record[5:])
+}
+
+// parseClientHelloSNI walks the fixed-size fields of a TLS 1.2/1.3
+// ClientHello (RFC 5246 §7.4.1.2) to reach the extensions block, then scans
+// for the server_name extension (RFC 6066 §3).
+func parseClientHelloSNI(hello []byte) (string, error) {
+	// handshake type(1) + length(3) + client_version(2) + random(32)
+	pos := 38
+	if pos >= len(hello) {
+		return "", fmt.Errorf("client hello too short")
+	}
+
+	sessionIDLen := int(hello[pos])
+	pos += 1 + sessionIDLen
+	if pos+2 > len(hello) {
+		return "", fmt.Errorf("malformed client hello: session id")
+	}
+
+	cipherSuitesLen := int(hello[pos])<<8 | int(hello[pos+1])
+	pos += 2 + cipherSuitesLen
+	if pos+1 > len(hello) {
+		return "", fmt.Errorf("malformed client hello: cipher suites")
+	}
+
+	compressionLen := int(hello[pos])
+	pos += 1 + compressionLen
+	if pos+2 > len(hello) {
+		return "", fmt.Errorf("no extensions present")
+	}
+
+	extensionsLen := int(hello[pos])<<8 | int(hello[pos+1])
+	pos += 2
+	end := pos + extensionsLen
+	if end > len(hello) {
+		end = len(hello)
+	}
+
+	for pos+4 <= end {
+		extType := int(hello[pos])<<8 | int(hello[pos+1])
+		extLen := int(hello[pos+2])<<8 | int(hello[pos+3])
+		pos += 4
+		if pos+extLen > len(hello) {
+			break
+		}
+
+		if extType == 0x00 { // server_name
+			return parseServerNameExtension(hello[pos : pos+extLen])
+		}
+		pos += extLen
+	}
+
+	return "", fmt.Errorf("no server_name extension present")
+}
+
+func parseServerNameExtension(data []byte) (string, error) {
+	// server_name_list length(2) + name_type(1) + name length(2)
+	if len(data) < 5 {
+		return "", fmt.Errorf("malformed server_name extension")
+	}
+	nameLen := int(data[3])<<8 | int(data[4])
+	if 5+nameLen > len(data) {
+		return "", fmt.Errorf("malformed server_name extension")
+	}
+	return string(data[5 : 5+nameLen]), nil
+}
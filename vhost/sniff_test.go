@@ -0,0 +1,132 @@
+package vhost
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestPeekHTTPHost(t *testing.T) {
+	req := "GET /path HTTP/1.1\r\nHost: example.com\r\nUser-Agent: test\r\n\r\n"
+	r := bufio.NewReaderSize(strings.NewReader(req), MaxHeaderPeek)
+
+	host, err := PeekHTTPHost(r)
+	if err != nil {
+		t.Fatalf("PeekHTTPHost returned error: %v", err)
+	}
+	if host != "example.com" {
+		t.Errorf("got host %q, want %q", host, "example.com")
+	}
+
+	// Peeking must not consume any bytes; the full request should still be
+	// readable afterwards.
+	rest, _ := r.Peek(len(req))
+	if string(rest) != req {
+		t.Errorf("Peek consumed bytes: got %q, want %q", rest, req)
+	}
+}
+
+func TestPeekHTTPHostNoHostHeader(t *testing.T) {
+	req := "GET / HTTP/1.1\r\nUser-Agent: test\r\n\r\n"
+	r := bufio.NewReaderSize(strings.NewReader(req), MaxHeaderPeek)
+
+	if _, err := PeekHTTPHost(r); err == nil {
+		t.Error("expected an error for a request with no Host header")
+	}
+}
+
+func TestPeekHTTPHostLargeHeaders(t *testing.T) {
+	// A request with headers well past the default 4096-byte bufio.Reader
+	// size, to guard against the reader silently truncating the peek.
+	var b strings.Builder
+	b.WriteString("GET / HTTP/1.1\r\n")
+	b.WriteString("Host: example.com\r\n")
+	for i := 0; i < 100; i++ {
+		b.WriteString("X-Padding: ")
+		b.WriteString(strings.Repeat("a", 60))
+		b.WriteString("\r\n")
+	}
+	b.WriteString("\r\n")
+	req := b.String()
+	if len(req) <= 4096 {
+		t.Fatalf("test request too small: %d bytes", len(req))
+	}
+
+	r := bufio.NewReaderSize(strings.NewReader(req), MaxHeaderPeek)
+	host, err := PeekHTTPHost(r)
+	if err != nil {
+		t.Fatalf("PeekHTTPHost returned error: %v", err)
+	}
+	if host != "example.com" {
+		t.Errorf("got host %q, want %q", host, "example.com")
+	}
+}
+
+func TestPeekTLSServerName(t *testing.T) {
+	hello := buildClientHello("example.com")
+	r := bufio.NewReaderSize(bytes.NewReader(hello), MaxHeaderPeek)
+
+	host, err := PeekTLSServerName(r)
+	if err != nil {
+		t.Fatalf("PeekTLSServerName returned error: %v", err)
+	}
+	if host != "example.com" {
+		t.Errorf("got host %q, want %q", host, "example.com")
+	}
+}
+
+func TestPeekTLSServerNameNotAHandshake(t *testing.T) {
+	data := []byte{0x17, 0x03, 0x03, 0x00, 0x05, 0, 0, 0, 0, 0}
+	r := bufio.NewReaderSize(bytes.NewReader(data), MaxHeaderPeek)
+
+	if _, err := PeekTLSServerName(r); err == nil {
+		t.Error("expected an error for a non-handshake record")
+	}
+}
+
+// buildClientHello constructs a minimal, well-formed TLS 1.2 ClientHello
+// record carrying a single server_name extension for host.
+func buildClientHello(host string) []byte {
+	serverName := []byte(host)
+
+	// server_name extension: list length(2) + name_type(1) + name length(2) + name
+	sniExt := new(bytes.Buffer)
+	sniExt.Write([]byte{0x00, 0x00}) // extension type: server_name
+	nameEntry := new(bytes.Buffer)
+	nameEntry.WriteByte(0x00) // name_type: host_name
+	nameEntry.Write([]byte{byte(len(serverName) >> 8), byte(len(serverName))})
+	nameEntry.Write(serverName)
+	listLen := nameEntry.Len()
+	extBody := new(bytes.Buffer)
+	extBody.Write([]byte{byte(listLen >> 8), byte(listLen)})
+	extBody.Write(nameEntry.Bytes())
+	sniExt.Write([]byte{byte(extBody.Len() >> 8), byte(extBody.Len())})
+	sniExt.Write(extBody.Bytes())
+
+	extensions := sniExt.Bytes()
+
+	body := new(bytes.Buffer)
+	body.Write([]byte{0x03, 0x03})             // client_version: TLS 1.2
+	body.Write(make([]byte, 32))               // random
+	body.WriteByte(0x00)                       // session_id length: 0
+	body.Write([]byte{0x00, 0x02, 0x00, 0x2f}) // cipher_suites length(2) + one suite
+	body.Write([]byte{0x01, 0x00})             // compression_methods length(1) + null method
+	body.Write([]byte{byte(len(extensions) >> 8), byte(len(extensions))})
+	body.Write(extensions)
+
+	handshake := new(bytes.Buffer)
+	handshake.WriteByte(0x01) // handshake type: client_hello
+	hlen := body.Len()
+	handshake.Write([]byte{byte(hlen >> 16), byte(hlen >> 8), byte(hlen)})
+	handshake.Write(body.Bytes())
+
+	record := new(bytes.Buffer)
+	record.WriteByte(0x16)           // content type: handshake
+	record.Write([]byte{0x03, 0x03}) // record version: TLS 1.2
+	rlen := handshake.Len()
+	record.Write([]byte{byte(rlen >> 8), byte(rlen)})
+	record.Write(handshake.Bytes())
+
+	return record.Bytes()
+}